@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/iterator"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+)
+
+// listAlertPolicies lists every alert policy configured for the project.
+func (m *MetricSet) listAlertPolicies(ctx context.Context) ([]*monitoringpb.AlertPolicy, error) {
+	req := &monitoringpb.ListAlertPoliciesRequest{
+		Name: fmt.Sprintf("projects/%s", m.config.ProjectID),
+	}
+
+	var policies []*monitoringpb.AlertPolicy
+	it := m.policyClient.ListAlertPolicies(ctx, req)
+	for {
+		policy, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// alertPolicyEvents turns every alert policy into an event.
+func alertPolicyEvents(policies []*monitoringpb.AlertPolicy) []common.MapStr {
+	events := make([]common.MapStr, 0, len(policies))
+	for _, p := range policies {
+		events = append(events, alertPolicyEvent(p))
+	}
+	return events
+}
+
+// alertPolicyEvent turns a single AlertPolicy into the fields documented
+// for this metricset: its name, display name and the filter/aligner/
+// threshold of each of its conditions.
+func alertPolicyEvent(p *monitoringpb.AlertPolicy) common.MapStr {
+	conditions := make([]common.MapStr, 0, len(p.Conditions))
+	for _, c := range p.Conditions {
+		conditions = append(conditions, conditionEvent(c))
+	}
+
+	return common.MapStr{
+		"policy": common.MapStr{
+			"name":         p.Name,
+			"display_name": p.DisplayName,
+			"enabled":      p.Enabled.GetValue(),
+			"conditions":   conditions,
+		},
+	}
+}
+
+// conditionEvent surfaces the filter, aligner and threshold of a single
+// alert policy condition, when it's a metric threshold condition (the kind
+// this module's metrics can already be collecting).
+func conditionEvent(c *monitoringpb.AlertPolicy_Condition) common.MapStr {
+	event := common.MapStr{
+		"display_name": c.DisplayName,
+	}
+
+	threshold := c.GetConditionThreshold()
+	if threshold == nil {
+		return event
+	}
+
+	event["filter"] = threshold.Filter
+	event["threshold_value"] = threshold.ThresholdValue
+	if len(threshold.Aggregations) > 0 {
+		event["aligner"] = threshold.Aggregations[0].PerSeriesAligner.String()
+	}
+
+	return event
+}
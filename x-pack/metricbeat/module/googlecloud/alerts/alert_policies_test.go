@@ -0,0 +1,68 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+)
+
+func TestAlertPolicyEvent(t *testing.T) {
+	p := &monitoringpb.AlertPolicy{
+		Name:        "projects/my-project/alertPolicies/1234",
+		DisplayName: "High CPU",
+		Enabled:     &wrappers.BoolValue{Value: true},
+		Conditions: []*monitoringpb.AlertPolicy_Condition{
+			{
+				DisplayName: "CPU above threshold",
+				Condition: &monitoringpb.AlertPolicy_Condition_ConditionThreshold{
+					ConditionThreshold: &monitoringpb.AlertPolicy_Condition_MetricThreshold{
+						Filter:         `metric.type="compute.googleapis.com/instance/cpu/utilization"`,
+						ThresholdValue: 0.8,
+						Aggregations: []*monitoringpb.Aggregation{
+							{PerSeriesAligner: monitoringpb.Aggregation_ALIGN_MEAN},
+						},
+					},
+				},
+			},
+			{
+				DisplayName: "Log match condition",
+			},
+		},
+	}
+
+	event := alertPolicyEvent(p)
+
+	policy, ok := event["policy"].(common.MapStr)
+	assert.True(t, ok)
+	assert.Equal(t, "projects/my-project/alertPolicies/1234", policy["name"])
+	assert.Equal(t, "High CPU", policy["display_name"])
+	assert.Equal(t, true, policy["enabled"])
+
+	conditions, ok := policy["conditions"].([]common.MapStr)
+	assert.True(t, ok)
+	assert.Len(t, conditions, 2)
+
+	assert.Equal(t, "CPU above threshold", conditions[0]["display_name"])
+	assert.Equal(t, `metric.type="compute.googleapis.com/instance/cpu/utilization"`, conditions[0]["filter"])
+	assert.Equal(t, 0.8, conditions[0]["threshold_value"])
+	assert.Equal(t, "ALIGN_MEAN", conditions[0]["aligner"])
+
+	assert.Equal(t, "Log match condition", conditions[1]["display_name"])
+	assert.NotContains(t, conditions[1], "filter")
+}
+
+func TestConditionEventWithoutThreshold(t *testing.T) {
+	c := &monitoringpb.AlertPolicy_Condition{DisplayName: "Log match condition"}
+
+	event := conditionEvent(c)
+
+	assert.Equal(t, common.MapStr{"display_name": "Log match condition"}, event)
+}
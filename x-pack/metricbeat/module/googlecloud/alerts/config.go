@@ -0,0 +1,18 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import "time"
+
+// config holds the alerts metricset configuration as defined by the user in
+// metricbeat.yml. It reuses the same credentials/project/period shape as
+// the stackdriver metricset, since both talk to the same Monitoring API
+// project.
+type config struct {
+	ProjectID           string        `config:"project_id" validate:"required"`
+	CredentialsFilePath string        `config:"credentials_file_path"`
+	CredentialsJSONKey  string        `config:"credentials_json_key"`
+	Period              time.Duration `config:"period" validate:"required"`
+}
@@ -0,0 +1,204 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+)
+
+// The Monitoring v3 API has no "incidents" resource to list directly - the
+// Console's incidents feed isn't part of the public API surface. Instead,
+// an "open incident" is derived from the policy's conditions: for every
+// ConditionThreshold, fetch the condition's own filter (with its first
+// aggregation, if any) over a short recent window and compare the latest
+// point against its threshold. The condition's duration and trigger
+// settings are not taken into account.
+const incidentLookbackWindow = 10 * time.Minute
+
+// incident is an alert policy condition that is currently breaching its
+// threshold, standing in for the "open Incident" this metricset reports.
+type incident struct {
+	PolicyName     string
+	ConditionName  string
+	Filter         string
+	Comparison     string
+	ThresholdValue float64
+	ObservedValue  float64
+	ResourceLabels common.MapStr
+	StartedAt      time.Time
+}
+
+// evaluateOpenIncidents checks every ConditionThreshold of every enabled
+// policy and reports the ones currently breaching their threshold.
+func (m *MetricSet) evaluateOpenIncidents(ctx context.Context, policies []*monitoringpb.AlertPolicy) ([]incident, error) {
+	var incidents []incident
+	for _, p := range policies {
+		if !p.GetEnabled().GetValue() {
+			continue
+		}
+		for _, c := range p.Conditions {
+			threshold := c.GetConditionThreshold()
+			if threshold == nil {
+				continue
+			}
+
+			open, err := m.evaluateCondition(ctx, p, c, threshold)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error evaluating condition %q of policy %q", c.DisplayName, p.Name)
+			}
+			incidents = append(incidents, open...)
+		}
+	}
+	return incidents, nil
+}
+
+// evaluateCondition lists the condition's own filter over the lookback
+// window and reports an incident for every series whose latest point
+// breaches the threshold.
+func (m *MetricSet) evaluateCondition(ctx context.Context, p *monitoringpb.AlertPolicy, c *monitoringpb.AlertPolicy_Condition, threshold *monitoringpb.AlertPolicy_Condition_MetricThreshold) ([]incident, error) {
+	now := time.Now()
+	req := &monitoringpb.ListTimeSeriesRequest{
+		Name:   fmt.Sprintf("projects/%s", m.config.ProjectID),
+		Filter: threshold.Filter,
+		Interval: &monitoringpb.TimeInterval{
+			EndTime:   mustTimestamp(now),
+			StartTime: mustTimestamp(now.Add(-incidentLookbackWindow)),
+		},
+		View: monitoringpb.ListTimeSeriesRequest_FULL,
+	}
+	if len(threshold.Aggregations) > 0 {
+		req.Aggregation = threshold.Aggregations[0]
+	}
+
+	var incidents []incident
+	it := m.metricClient.ListTimeSeries(ctx, req)
+	for {
+		ts, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		point := latestPoint(ts)
+		if point == nil {
+			continue
+		}
+
+		observed := typedValueToFloat64(point.Value)
+		if !breaches(threshold.Comparison, observed, threshold.ThresholdValue) {
+			continue
+		}
+
+		incidents = append(incidents, incident{
+			PolicyName:     p.Name,
+			ConditionName:  c.DisplayName,
+			Filter:         threshold.Filter,
+			Comparison:     threshold.Comparison.String(),
+			ThresholdValue: threshold.ThresholdValue,
+			ObservedValue:  observed,
+			ResourceLabels: resourceLabels(ts),
+			StartedAt:      pointTime(point),
+		})
+	}
+	return incidents, nil
+}
+
+// latestPoint returns the most recent point of a time series. ListTimeSeries
+// returns points ordered most-recent-first.
+func latestPoint(ts *monitoringpb.TimeSeries) *monitoringpb.Point {
+	if len(ts.Points) == 0 {
+		return nil
+	}
+	return ts.Points[0]
+}
+
+func pointTime(p *monitoringpb.Point) time.Time {
+	ts, err := ptypes.Timestamp(p.GetInterval().GetEndTime())
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
+func resourceLabels(ts *monitoringpb.TimeSeries) common.MapStr {
+	labels := common.MapStr{}
+	for k, v := range ts.GetResource().GetLabels() {
+		labels[k] = v
+	}
+	return labels
+}
+
+func mustTimestamp(t time.Time) *timestamp.Timestamp {
+	ts, _ := ptypes.TimestampProto(t)
+	return ts
+}
+
+// typedValueToFloat64 normalizes the numeric kinds a threshold condition
+// can compare against (double, int64) into a float64.
+func typedValueToFloat64(v *monitoringpb.TypedValue) float64 {
+	switch val := v.GetValue().(type) {
+	case *monitoringpb.TypedValue_DoubleValue:
+		return val.DoubleValue
+	case *monitoringpb.TypedValue_Int64Value:
+		return float64(val.Int64Value)
+	default:
+		return 0
+	}
+}
+
+// breaches reports whether observed breaches threshold under comparison,
+// mirroring the semantics of AlertPolicy_Condition_MetricThreshold's
+// Comparison field.
+func breaches(comparison monitoringpb.ComparisonType, observed, threshold float64) bool {
+	switch comparison {
+	case monitoringpb.ComparisonType_COMPARISON_GT:
+		return observed > threshold
+	case monitoringpb.ComparisonType_COMPARISON_GE:
+		return observed >= threshold
+	case monitoringpb.ComparisonType_COMPARISON_LT:
+		return observed < threshold
+	case monitoringpb.ComparisonType_COMPARISON_LE:
+		return observed <= threshold
+	case monitoringpb.ComparisonType_COMPARISON_EQ:
+		return observed == threshold
+	case monitoringpb.ComparisonType_COMPARISON_NE:
+		return observed != threshold
+	default:
+		return false
+	}
+}
+
+// incidentEvents turns the incidents list into events.
+func incidentEvents(incidents []incident) []common.MapStr {
+	events := make([]common.MapStr, 0, len(incidents))
+	for _, inc := range incidents {
+		events = append(events, common.MapStr{
+			"incident": common.MapStr{
+				"policy_name":     inc.PolicyName,
+				"condition_name":  inc.ConditionName,
+				"filter":          inc.Filter,
+				"comparison":      inc.Comparison,
+				"threshold_value": inc.ThresholdValue,
+				"observed_value":  inc.ObservedValue,
+				"resource_labels": inc.ResourceLabels,
+				"started_at":      inc.StartedAt,
+				"state":           "OPEN",
+			},
+		})
+	}
+	return events
+}
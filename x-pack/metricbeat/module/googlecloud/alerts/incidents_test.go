@@ -0,0 +1,75 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+)
+
+func TestBreaches(t *testing.T) {
+	cases := []struct {
+		title      string
+		comparison monitoringpb.ComparisonType
+		observed   float64
+		threshold  float64
+		expected   bool
+	}{
+		{"GT breaches when observed is strictly greater", monitoringpb.ComparisonType_COMPARISON_GT, 0.9, 0.8, true},
+		{"GT does not breach when equal", monitoringpb.ComparisonType_COMPARISON_GT, 0.8, 0.8, false},
+		{"GE breaches when equal", monitoringpb.ComparisonType_COMPARISON_GE, 0.8, 0.8, true},
+		{"LT breaches when observed is strictly less", monitoringpb.ComparisonType_COMPARISON_LT, 0.1, 0.8, true},
+		{"LE breaches when equal", monitoringpb.ComparisonType_COMPARISON_LE, 0.8, 0.8, true},
+		{"EQ breaches when equal", monitoringpb.ComparisonType_COMPARISON_EQ, 0.8, 0.8, true},
+		{"NE breaches when different", monitoringpb.ComparisonType_COMPARISON_NE, 0.9, 0.8, true},
+		{"unspecified comparison never breaches", monitoringpb.ComparisonType_COMPARISON_UNSPECIFIED, 100, 0.8, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			assert.Equal(t, c.expected, breaches(c.comparison, c.observed, c.threshold))
+		})
+	}
+}
+
+func TestIncidentEvents(t *testing.T) {
+	started := time.Date(2026, 7, 26, 12, 0, 0, 0, time.UTC)
+	incidents := []incident{
+		{
+			PolicyName:     "projects/my-project/alertPolicies/1234",
+			ConditionName:  "CPU above threshold",
+			Filter:         `metric.type="compute.googleapis.com/instance/cpu/utilization"`,
+			Comparison:     "COMPARISON_GT",
+			ThresholdValue: 0.8,
+			ObservedValue:  0.95,
+			ResourceLabels: common.MapStr{"zone": "us-east1-b"},
+			StartedAt:      started,
+		},
+	}
+
+	events := incidentEvents(incidents)
+
+	assert.Len(t, events, 1)
+	inc, ok := events[0]["incident"].(common.MapStr)
+	assert.True(t, ok)
+	assert.Equal(t, "projects/my-project/alertPolicies/1234", inc["policy_name"])
+	assert.Equal(t, "CPU above threshold", inc["condition_name"])
+	assert.Equal(t, `metric.type="compute.googleapis.com/instance/cpu/utilization"`, inc["filter"])
+	assert.Equal(t, "COMPARISON_GT", inc["comparison"])
+	assert.Equal(t, 0.8, inc["threshold_value"])
+	assert.Equal(t, 0.95, inc["observed_value"])
+	assert.Equal(t, common.MapStr{"zone": "us-east1-b"}, inc["resource_labels"])
+	assert.Equal(t, started, inc["started_at"])
+	assert.Equal(t, "OPEN", inc["state"])
+}
+
+func TestIncidentEventsEmpty(t *testing.T) {
+	assert.Empty(t, incidentEvents(nil))
+}
@@ -0,0 +1,94 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package alerts
+
+import (
+	"context"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+
+	"github.com/elastic/beats/v7/metricbeat/mb"
+)
+
+func init() {
+	mb.Registry.MustAddMetricSet("googlecloud", "alerts", New)
+}
+
+// MetricSet reports which Stackdriver alert policies exist and which of
+// their conditions are currently breaching their threshold, closing the
+// loop between the raw metrics the stackdriver metricset collects and
+// Stackdriver's own alerting layer.
+type MetricSet struct {
+	mb.BaseMetricSet
+	config       config
+	policyClient *monitoring.AlertPolicyClient
+	metricClient *monitoring.MetricClient
+}
+
+// New creates a new alerts metricset.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	cfg := config{}
+	if err := base.Module().UnpackConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	policyClient, err := monitoring.NewAlertPolicyClient(ctx, clientOptions(cfg)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating AlertPolicy client")
+	}
+
+	metricClient, err := monitoring.NewMetricClient(ctx, clientOptions(cfg)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Metric client")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		config:        cfg,
+		policyClient:  policyClient,
+		metricClient:  metricClient,
+	}, nil
+}
+
+// Fetch lists every alert policy and reports every condition that's
+// currently breaching its threshold as an open incident.
+func (m *MetricSet) Fetch(report mb.ReporterV2) error {
+	ctx := context.Background()
+
+	policies, err := m.listAlertPolicies(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error listing alert policies")
+	}
+	for _, event := range alertPolicyEvents(policies) {
+		report.Event(mb.Event{MetricSetFields: event})
+	}
+
+	incidents, err := m.evaluateOpenIncidents(ctx, policies)
+	if err != nil {
+		return errors.Wrap(err, "error evaluating open incidents")
+	}
+	for _, event := range incidentEvents(incidents) {
+		report.Event(mb.Event{MetricSetFields: event})
+	}
+
+	return nil
+}
+
+// clientOptions builds the auth options shared by every Monitoring API
+// client this metricset creates.
+func clientOptions(cfg config) []option.ClientOption {
+	var opts []option.ClientOption
+	switch {
+	case cfg.CredentialsFilePath != "":
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFilePath))
+	case cfg.CredentialsJSONKey != "":
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.CredentialsJSONKey)))
+	}
+	return opts
+}
@@ -0,0 +1,123 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package stackdriver
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+)
+
+// config holds the stackdriver metricset configuration as defined by the
+// user in metricbeat.yml.
+type config struct {
+	ProjectID           string        `config:"project_id" validate:"required"`
+	Zone                string        `config:"zone"`
+	Region              string        `config:"region"`
+	Zones               []string      `config:"zones"`
+	Regions             []string      `config:"regions"`
+	CredentialsFilePath string        `config:"credentials_file_path"`
+	CredentialsJSONKey  string        `config:"credentials_json_key"`
+	Period              time.Duration `config:"period" validate:"required"`
+
+	// Queries lists the time series to collect. Each entry either names a
+	// metric_type, which is turned into a filter-based ListTimeSeries
+	// request the same way this metricset has always worked, or an mql
+	// query, which is sent to the MQL QueryTimeSeries endpoint instead.
+	Queries []queryConfig `config:"queries"`
+
+	// Metrics lets users pre-aggregate high-cardinality metric_type queries
+	// before they're shipped, instead of always requesting raw per-series
+	// data. Entries are matched against a query's metric_type with a glob
+	// where `*` spans `/`, so a single entry can cover every metric type
+	// under a service or resource prefix.
+	Metrics []metricAggregationConfig `config:"metrics"`
+
+	// MetricDescriptorCacheTTL controls how long a metric's ingest delay and
+	// sample period, read from its MetricDescriptor, are cached before
+	// being looked up again.
+	MetricDescriptorCacheTTL time.Duration `config:"metric_descriptor_cache_ttl"`
+}
+
+// defaultConfig returns the config defaults applied before the user's
+// config is unpacked on top of it.
+func defaultConfig() config {
+	return config{
+		MetricDescriptorCacheTTL: time.Hour,
+	}
+}
+
+// queryConfig describes a single time series to request from the Cloud
+// Monitoring API, either as a metric type (filter-based) or as a raw MQL
+// query. Exactly one of MetricType or MQL must be set.
+type queryConfig struct {
+	MetricType string `config:"metric_type"`
+	MQL        string `config:"mql"`
+
+	// Selectors scopes the query to arbitrary resource or metric labels on
+	// top of the module-level zone/region, e.g. project_id, cluster_name or
+	// backend_target_name.
+	Selectors []labelSelectorConfig `config:"selectors"`
+}
+
+// Validate makes sure a queryConfig is usable: exactly one of metric_type or
+// mql must be given, a query cannot be both or neither.
+func (c queryConfig) Validate() error {
+	if c.MetricType == "" && c.MQL == "" {
+		return errors.New("query must set either metric_type or mql")
+	}
+	if c.MetricType != "" && c.MQL != "" {
+		return errors.New("query cannot set both metric_type and mql")
+	}
+	return nil
+}
+
+// isMQL reports whether this query should be executed against the MQL
+// QueryTimeSeries endpoint instead of the filter-based ListTimeSeries one.
+func (c queryConfig) isMQL() bool {
+	return c.MQL != ""
+}
+
+// labelSelectorConfig scopes a query to a resource or metric label, such as
+// `resource.labels.cluster_name` or `resource.labels.backend_target_name`.
+// Values are OR'd together; Operator controls how each one is compared
+// ("starts_with", "ends_with" or "regex" for monitoring.regex.full_match,
+// an exact match when left empty).
+type labelSelectorConfig struct {
+	Label    string   `config:"label" validate:"required"`
+	Values   []string `config:"values" validate:"required"`
+	Operator string   `config:"operator"`
+}
+
+// metricAggregationConfig configures cross-series aggregation for one
+// metric type (or glob of metric types), mirroring the aggregation block of
+// a Terraform google_monitoring_alert_policy condition: an aligner to apply
+// per series, a reducer to combine series together, and the labels to
+// group by when reducing.
+type metricAggregationConfig struct {
+	MetricType         string   `config:"metric_type" validate:"required"`
+	Aligner            string   `config:"aligner"`
+	CrossSeriesReducer string   `config:"cross_series_reducer"`
+	GroupByFields      []string `config:"group_by_fields"`
+}
+
+// Validate makes sure aligner and cross_series_reducer, when set, name a
+// real Aggregation_Aligner/Aggregation_Reducer enum value. Left unchecked, a
+// typo silently falls back to ALIGN_NONE/REDUCE_NONE and ships unaggregated,
+// high-cardinality data with no warning.
+func (c metricAggregationConfig) Validate() error {
+	if c.Aligner != "" {
+		if _, ok := monitoringpb.Aggregation_Aligner_value[c.Aligner]; !ok {
+			return errors.Errorf("unknown aligner %q", c.Aligner)
+		}
+	}
+	if c.CrossSeriesReducer != "" {
+		if _, ok := monitoringpb.Aggregation_Reducer_value[c.CrossSeriesReducer]; !ok {
+			return errors.Errorf("unknown cross_series_reducer %q", c.CrossSeriesReducer)
+		}
+	}
+	return nil
+}
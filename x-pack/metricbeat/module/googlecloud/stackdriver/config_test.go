@@ -0,0 +1,61 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package stackdriver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricAggregationConfigValidate(t *testing.T) {
+	cases := []struct {
+		title     string
+		c         metricAggregationConfig
+		expectErr bool
+	}{
+		{
+			"empty aligner and reducer are left to their defaults",
+			metricAggregationConfig{MetricType: "compute.googleapis.com/instance/cpu/utilization"},
+			false,
+		},
+		{
+			"known aligner and reducer",
+			metricAggregationConfig{
+				MetricType:         "compute.googleapis.com/instance/cpu/utilization",
+				Aligner:            "ALIGN_MEAN",
+				CrossSeriesReducer: "REDUCE_PERCENTILE_99",
+			},
+			false,
+		},
+		{
+			"unknown aligner",
+			metricAggregationConfig{
+				MetricType: "compute.googleapis.com/instance/cpu/utilization",
+				Aligner:    "ALIGN_MEENN",
+			},
+			true,
+		},
+		{
+			"unknown cross_series_reducer",
+			metricAggregationConfig{
+				MetricType:         "compute.googleapis.com/instance/cpu/utilization",
+				CrossSeriesReducer: "REDUCE_PERCENTIL_99",
+			},
+			true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			err := c.c.Validate()
+			if c.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
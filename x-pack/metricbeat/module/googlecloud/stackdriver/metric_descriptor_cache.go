@@ -0,0 +1,65 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+)
+
+// metricDescriptorCacheEntry holds the per-metric cadence read out of a
+// MetricDescriptor's metadata.
+type metricDescriptorCacheEntry struct {
+	ingestDelay  time.Duration
+	samplePeriod time.Duration
+	expiresAt    time.Time
+}
+
+// metricDescriptorCache remembers the ingest delay and sample period of
+// every metric type this requester has already described, so
+// projects.metricDescriptors.get is only called once per metric type per
+// TTL window instead of on every collection.
+//
+// Metricbeat rebuilds the metricset (and so this requester) from scratch on
+// every config reload, which is what actually evicts the cache; there's no
+// separate reload hook to wire up.
+type metricDescriptorCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]metricDescriptorCacheEntry
+}
+
+// newMetricDescriptorCache builds an empty cache with the given TTL.
+func newMetricDescriptorCache(ttl time.Duration) *metricDescriptorCache {
+	return &metricDescriptorCache{
+		ttl:     ttl,
+		entries: make(map[string]metricDescriptorCacheEntry),
+	}
+}
+
+// get returns the cached entry for metricType, if any and not expired.
+func (c *metricDescriptorCache) get(metricType string) (metricDescriptorCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[metricType]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return metricDescriptorCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// set stores ingestDelay/samplePeriod for metricType, valid until the
+// cache's TTL elapses.
+func (c *metricDescriptorCache) set(metricType string, ingestDelay, samplePeriod time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[metricType] = metricDescriptorCacheEntry{
+		ingestDelay:  ingestDelay,
+		samplePeriod: samplePeriod,
+		expiresAt:    time.Now().Add(c.ttl),
+	}
+}
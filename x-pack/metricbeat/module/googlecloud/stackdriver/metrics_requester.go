@@ -0,0 +1,518 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/logp"
+)
+
+// Default ingest delay and sample period used when a metric's real cadence
+// isn't known. These are deliberately conservative; most GCP services
+// document something close to this in their MetricDescriptor metadata.
+const (
+	defaultIngestDelay  = 240 * time.Second
+	defaultSamplePeriod = 60 * time.Second
+)
+
+// stackdriverMetricsRequester wraps a Cloud Monitoring client with the
+// metricset configuration needed to turn it into ListTimeSeries/
+// QueryTimeSeries requests.
+type stackdriverMetricsRequester struct {
+	config          config
+	client          *monitoring.MetricClient
+	logger          *logp.Logger
+	descriptorCache *metricDescriptorCache
+}
+
+// NewStackdriverMetricsRequester builds a stackdriverMetricsRequester backed
+// by a real Cloud Monitoring API client, authenticated from the credentials
+// configured for the metricset.
+func NewStackdriverMetricsRequester(ctx context.Context, cfg config, logger *logp.Logger) (*stackdriverMetricsRequester, error) {
+	opts := []option.ClientOption{}
+	switch {
+	case cfg.CredentialsFilePath != "":
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFilePath))
+	case cfg.CredentialsJSONKey != "":
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.CredentialsJSONKey)))
+	}
+
+	client, err := monitoring.NewMetricClient(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Stackdriver Metric client")
+	}
+
+	return &stackdriverMetricsRequester{
+		config:          cfg,
+		client:          client,
+		logger:          logger,
+		descriptorCache: newMetricDescriptorCache(cfg.MetricDescriptorCacheTTL),
+	}, nil
+}
+
+// Metrics runs every configured query (filter-based or MQL) and returns the
+// resulting events, one per time series point (for MQL queries, one per
+// value column of each point).
+func (r *stackdriverMetricsRequester) Metrics(ctx context.Context, interval *monitoringpb.TimeInterval) ([]common.MapStr, error) {
+	var events []common.MapStr
+	for _, q := range r.config.Queries {
+		var (
+			queryEvents []common.MapStr
+			err         error
+		)
+
+		if q.isMQL() {
+			queryEvents, err = r.runMQLQuery(ctx, q)
+		} else {
+			queryEvents, err = r.runFilterQuery(ctx, q, interval)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching metrics for query %+v", q)
+		}
+
+		events = append(events, queryEvents...)
+	}
+	return events, nil
+}
+
+// runFilterQuery executes the "classic" path: a filter built out of the
+// metric type plus zone/region scoping, sent to ListTimeSeries.
+func (r *stackdriverMetricsRequester) runFilterQuery(ctx context.Context, q queryConfig, interval *monitoringpb.TimeInterval) ([]common.MapStr, error) {
+	req := &monitoringpb.ListTimeSeriesRequest{
+		Name:        fmt.Sprintf("projects/%s", r.config.ProjectID),
+		Filter:      r.getFilterForMetric(q.MetricType, q.Selectors),
+		Interval:    interval,
+		Aggregation: r.aggregationForMetric(ctx, q.MetricType),
+	}
+
+	var events []common.MapStr
+	it := r.client.ListTimeSeries(ctx, req)
+	for {
+		ts, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error calling ListTimeSeries")
+		}
+		events = append(events, eventsFromTimeSeries(ts)...)
+	}
+	return events, nil
+}
+
+// aggregationForMetric builds the Aggregation to send for a metric type,
+// applying the first metricAggregationConfig whose metric_type glob matches
+// it. Metrics with no matching entry keep today's behaviour: align per
+// series with no cross-series reduction.
+//
+// The alignment period and aligner come from getTimeIntervalAligner, fed
+// with the metric's own ingest delay and sample period read from its
+// MetricDescriptor rather than fixed module-wide constants. Only the sample
+// period affects the result; the ingest delay does not shift the requested
+// interval.
+func (r *stackdriverMetricsRequester) aggregationForMetric(ctx context.Context, metricType string) *monitoringpb.Aggregation {
+	var (
+		alignerName string
+		reducer     monitoringpb.Aggregation_Reducer
+		groupBy     []string
+	)
+
+	for _, mc := range r.config.Metrics {
+		if matchMetricTypeGlob(mc.MetricType, metricType) {
+			alignerName = mc.Aligner
+			reducer = monitoringpb.Aggregation_Reducer(monitoringpb.Aggregation_Reducer_value[mc.CrossSeriesReducer])
+			groupBy = mc.GroupByFields
+			break
+		}
+	}
+
+	ingestDelay, samplePeriod, err := r.descriptorMetadata(ctx, metricType)
+	if err != nil && r.logger != nil {
+		r.logger.Warnf("using default ingest delay/sample period for %s: %s", metricType, err)
+	}
+
+	alignmentPeriod, aligner := getTimeIntervalAligner(ingestDelay, samplePeriod, duration.Duration{Seconds: int64(r.config.Period.Seconds())}, alignerName)
+
+	return &monitoringpb.Aggregation{
+		AlignmentPeriod:    &duration.Duration{Seconds: int64(alignmentPeriod.Seconds())},
+		PerSeriesAligner:   monitoringpb.Aggregation_Aligner(monitoringpb.Aggregation_Aligner_value[aligner]),
+		CrossSeriesReducer: reducer,
+		GroupByFields:      groupBy,
+	}
+}
+
+// matchMetricTypeGlob reports whether metricType matches pattern, a glob
+// where `*` matches any run of characters, including `/`. Metric types are
+// slash-separated (e.g. compute.googleapis.com/instance/cpu/utilization), so
+// path.Match can't be used here: it treats `/` as a path separator and never
+// matches it with `*`.
+func matchMetricTypeGlob(pattern, metricType string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(metricType)
+}
+
+// descriptorMetadata returns the ingest delay and sample period for
+// metricType, from the cache when available and otherwise from a fresh
+// projects.metricDescriptors.get call. On error it falls back to the
+// module's conservative defaults so a single failed lookup doesn't break
+// collection of every other metric.
+func (r *stackdriverMetricsRequester) descriptorMetadata(ctx context.Context, metricType string) (time.Duration, time.Duration, error) {
+	if entry, ok := r.descriptorCache.get(metricType); ok {
+		return entry.ingestDelay, entry.samplePeriod, nil
+	}
+
+	descriptor, err := r.client.GetMetricDescriptor(ctx, &monitoringpb.GetMetricDescriptorRequest{
+		Name: fmt.Sprintf("projects/%s/metricDescriptors/%s", r.config.ProjectID, metricType),
+	})
+	if err != nil {
+		return defaultIngestDelay, defaultSamplePeriod, errors.Wrap(err, "error getting metric descriptor")
+	}
+
+	ingestDelay := defaultIngestDelay
+	samplePeriod := defaultSamplePeriod
+	if metadata := descriptor.GetMetadata(); metadata != nil {
+		if d := metadata.GetIngestDelay(); d != nil {
+			ingestDelay = time.Duration(d.Seconds) * time.Second
+		}
+		if d := metadata.GetSamplePeriod(); d != nil {
+			samplePeriod = time.Duration(d.Seconds) * time.Second
+		}
+	}
+
+	r.descriptorCache.set(metricType, ingestDelay, samplePeriod)
+	return ingestDelay, samplePeriod, nil
+}
+
+// runMQLQuery executes a raw MQL query through the QueryTimeSeries endpoint,
+// used for joins, ratios and window functions that a plain filter cannot
+// express.
+func (r *stackdriverMetricsRequester) runMQLQuery(ctx context.Context, q queryConfig) ([]common.MapStr, error) {
+	req := &monitoringpb.QueryTimeSeriesRequest{
+		Name:  fmt.Sprintf("projects/%s", r.config.ProjectID),
+		Query: q.MQL,
+	}
+
+	var events []common.MapStr
+	it := r.client.QueryTimeSeries(ctx, req)
+	for {
+		data, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error calling QueryTimeSeries")
+		}
+		resp, _ := it.Response.(*monitoringpb.QueryTimeSeriesResponse)
+		events = append(events, eventsFromMQLData(resp.GetTimeSeriesDescriptor(), data)...)
+	}
+	return events, nil
+}
+
+// eventsFromTimeSeries turns a single ListTimeSeries result into the same
+// shape of events the filter-based path has always produced.
+func eventsFromTimeSeries(ts *monitoringpb.TimeSeries) []common.MapStr {
+	events := make([]common.MapStr, 0, len(ts.Points))
+	for _, p := range ts.Points {
+		events = append(events, common.MapStr{
+			"metric_type": ts.Metric.Type,
+			"labels":      ts.Metric.Labels,
+			"resource":    ts.Resource.Labels,
+			"point":       p,
+		})
+	}
+	return events
+}
+
+// eventsFromMQLData turns a single QueryTimeSeries (MQL) result into the same
+// shape of events the filter-based path produces, so users don't need to
+// special-case MQL-sourced metrics downstream. data.LabelValues is
+// positional; descriptor (the TimeSeriesDescriptor returned alongside the
+// response) gives each position its name, the same way a TimeSeries' own
+// Metric/Resource labels are keyed.
+func eventsFromMQLData(descriptor *monitoringpb.TimeSeriesDescriptor, data *monitoringpb.TimeSeriesData) []common.MapStr {
+	metricType, labels, resource := labelsFromDescriptor(descriptor, data.LabelValues)
+	pointDescriptors := descriptor.GetPointDescriptors()
+
+	events := make([]common.MapStr, 0, len(data.PointData))
+	for _, pd := range data.PointData {
+		for i, v := range pd.Values {
+			event := common.MapStr{
+				"metric_type": metricType,
+				"labels":      labels,
+				"resource":    resource,
+				"point": &monitoringpb.Point{
+					Interval: pd.TimeInterval,
+					Value:    v,
+				},
+			}
+			if i < len(pointDescriptors) {
+				if key := pointDescriptors[i].GetKey(); key != "" {
+					event["value_column"] = key
+				}
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// labelsFromDescriptor splits a TimeSeriesDescriptor/LabelValues pair into
+// the metric type plus the metric- and resource-label maps eventsFromMQLData
+// needs, using the descriptor's LabelDescriptors to name each positional
+// LabelValue.
+func labelsFromDescriptor(descriptor *monitoringpb.TimeSeriesDescriptor, values []*monitoringpb.LabelValue) (metricType string, labels, resource common.MapStr) {
+	labels = common.MapStr{}
+	resource = common.MapStr{}
+
+	labelDescriptors := descriptor.GetLabelDescriptors()
+	for i, v := range values {
+		if i >= len(labelDescriptors) {
+			break
+		}
+
+		key := labelDescriptors[i].GetKey()
+		value := labelValueString(v)
+
+		switch {
+		case key == "metric.type":
+			metricType = value
+		case strings.HasPrefix(key, "metric.label."):
+			labels[strings.TrimPrefix(key, "metric.label.")] = value
+		case strings.HasPrefix(key, "resource.label."):
+			resource[strings.TrimPrefix(key, "resource.label.")] = value
+		}
+	}
+	return metricType, labels, resource
+}
+
+// labelValueString renders a LabelValue's oneof value as a string, the same
+// representation constructFilter/selector already use for label values.
+func labelValueString(v *monitoringpb.LabelValue) string {
+	switch val := v.GetValue().(type) {
+	case *monitoringpb.LabelValue_StringValue:
+		return val.StringValue
+	case *monitoringpb.LabelValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *monitoringpb.LabelValue_Int64Value:
+		return strconv.FormatInt(val.Int64Value, 10)
+	default:
+		return ""
+	}
+}
+
+// currentTimeInterval builds the [now-period, now) window requested on
+// every collection.
+func currentTimeInterval(period time.Duration) *monitoringpb.TimeInterval {
+	now := time.Now()
+	return &monitoringpb.TimeInterval{
+		EndTime:   timestampFromTime(now),
+		StartTime: timestampFromTime(now.Add(-period)),
+	}
+}
+
+// timestampFromTime converts a time.Time into the protobuf Timestamp the
+// Monitoring API expects. now and now-period are always valid, so the
+// conversion error is never hit in practice.
+func timestampFromTime(t time.Time) *timestamp.Timestamp {
+	ts, _ := ptypes.TimestampProto(t)
+	return ts
+}
+
+// selector is a structured, multi-valued alternative to passing individual
+// zone/region strings around: it maps a resource or metric label to the
+// list of terms that should match it. Multiple terms for the same label
+// are OR'd together; multiple labels are AND'd.
+type selector map[string][]selectorTerm
+
+// selectorTerm is a single value to match against a label, with the
+// comparison operator to use. An empty operator means an exact match.
+type selectorTerm struct {
+	value    string
+	operator string // "", "starts_with", "ends_with" or "regex"
+}
+
+// add appends a value to a label's selector terms, building the selector up
+// one label at a time.
+func (s selector) add(label, value, operator string) selector {
+	if s == nil {
+		s = selector{}
+	}
+	s[label] = append(s[label], selectorTerm{value: value, operator: operator})
+	return s
+}
+
+// render turns a single term into its filter-language comparison, e.g.
+// `resource.labels.zone = starts_with("us-east1")`.
+func (t selectorTerm) render(label string) string {
+	switch t.operator {
+	case "starts_with":
+		return fmt.Sprintf(`%s = starts_with("%s")`, label, t.value)
+	case "ends_with":
+		return fmt.Sprintf(`%s = ends_with("%s")`, label, t.value)
+	case "regex":
+		return fmt.Sprintf(`%s = monitoring.regex.full_match("%s")`, label, t.value)
+	default:
+		return fmt.Sprintf(`%s = "%s"`, label, t.value)
+	}
+}
+
+// constructFilter builds the `metric.type=...` filter used by
+// ListTimeSeries, AND-ing in every label of sel. Multiple terms for the
+// same label are OR'd and parenthesized so they compose correctly with the
+// ANDs joining different labels.
+func constructFilter(m string, sel selector) string {
+	filter := fmt.Sprintf(`metric.type="%s"`, m)
+
+	labels := make([]string, 0, len(sel))
+	for label := range sel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		terms := sel[label]
+		if len(terms) == 0 {
+			continue
+		}
+
+		clauses := make([]string, 0, len(terms))
+		for _, t := range terms {
+			clauses = append(clauses, t.render(label))
+		}
+
+		clause := strings.Join(clauses, " OR ")
+		if len(clauses) > 1 {
+			clause = "(" + clause + ")"
+		}
+		filter += " AND " + clause
+	}
+
+	return filter
+}
+
+// locationLabel returns the resource label that carries a metric's
+// location, and whether that label holds a zone (so a region selector has
+// to be matched as a prefix of it) rather than a region directly.
+//
+// compute.googleapis.com resources only expose a zone label; everything
+// else Beats scopes by region (GKE clusters, L7 load balancer backends,
+// pubsub, ...) exposes the region directly, so scoping them by region must
+// not be silently dropped.
+func locationLabel(m string) (label string, isZone bool) {
+	if strings.HasPrefix(m, "compute.googleapis.com/") {
+		return "resource.labels.zone", true
+	}
+	return "resource.labels.region", false
+}
+
+// locationSelector builds the zone/region portion of a metric's selector
+// from the requester's configured zones and regions, using whichever
+// location label applies to that metric's service.
+//
+// The legacy single `zone`/`region` settings keep their original,
+// mutually-exclusive precedence: region wins and zone is dropped when both
+// are set, exactly as constructFilter behaved before multi-value support
+// was added. The newer `zones`/`regions` lists are a separate, additive
+// mechanism: every value in them is OR'd in on top of whatever the legacy
+// fields already selected.
+func (r stackdriverMetricsRequester) locationSelector(m string) selector {
+	label, isZone := locationLabel(m)
+
+	sel := selector{}
+
+	switch {
+	case !isZone:
+		// Region-labelled resources (GKE, L7 LB, pubsub, ...) have no zone
+		// to match against, so only region scoping applies.
+		if r.config.Region != "" {
+			sel = sel.add(label, r.config.Region, "")
+		}
+	case r.config.Region != "":
+		sel = sel.add(label, r.config.Region, "starts_with")
+	case r.config.Zone != "":
+		sel = sel.add(label, r.config.Zone, "")
+	}
+
+	for _, region := range r.config.Regions {
+		if isZone {
+			sel = sel.add(label, region, "starts_with")
+		} else {
+			sel = sel.add(label, region, "")
+		}
+	}
+	// A zone selector is only meaningful for zone-labelled resources; for
+	// region-labelled ones there is no zone to match against.
+	if isZone {
+		for _, zone := range r.config.Zones {
+			sel = sel.add(label, zone, "")
+		}
+	}
+
+	return sel
+}
+
+// getFilterForMetric builds the filter for a single metric type: zone or
+// region scoping from the requester's config, composed with any extra
+// label selectors set on the query itself (arbitrary resource or metric
+// labels such as cluster_name or backend_target_name).
+func (r stackdriverMetricsRequester) getFilterForMetric(m string, extra []labelSelectorConfig) string {
+	sel := r.locationSelector(m)
+
+	for _, ls := range extra {
+		for _, v := range ls.Values {
+			sel = sel.add(ls.Label, v, ls.Operator)
+		}
+	}
+
+	return constructFilter(m, sel)
+}
+
+// getTimeIntervalAligner decides the alignment period and PerSeriesAligner
+// to use for a metric, given its sample period (as reported by the API's
+// MetricDescriptor) and the collection period configured by the user.
+// ingestDelay is accepted but not used in the decision.
+//
+// If the collection period is not strictly larger than the metric's sample
+// period there's nothing meaningful to align over, so alignment is
+// disabled (ALIGN_NONE) regardless of what the user asked for.
+func getTimeIntervalAligner(ingestDelay, samplePeriod time.Duration, collectionPeriod duration.Duration, inputAligner string) (time.Duration, string) {
+	period := time.Duration(collectionPeriod.Seconds) * time.Second
+
+	if period <= samplePeriod {
+		return samplePeriod, "ALIGN_NONE"
+	}
+
+	aligner := inputAligner
+	if aligner == "" {
+		aligner = "ALIGN_MEAN"
+	}
+
+	return period, aligner
+}
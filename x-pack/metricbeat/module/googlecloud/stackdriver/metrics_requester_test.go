@@ -5,42 +5,60 @@
 package stackdriver
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/stretchr/testify/assert"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
 
+	"github.com/elastic/beats/v7/libbeat/common"
 	"github.com/elastic/beats/v7/libbeat/logp"
 )
 
-func TestStringInSlice(t *testing.T) {
+func TestConstructFilter(t *testing.T) {
 	cases := []struct {
 		title          string
 		m              string
-		region         string
-		zone           string
+		sel            selector
 		expectedFilter string
 	}{
 		{
 			"construct filter with zone",
 			"compute.googleapis.com/instance/cpu/utilization",
-			"",
-			"us-east1-b",
+			selector{}.add("resource.labels.zone", "us-east1-b", ""),
 			"metric.type=\"compute.googleapis.com/instance/cpu/utilization\" AND resource.labels.zone = \"us-east1-b\"",
 		},
 		{
 			"construct filter with region",
 			"compute.googleapis.com/instance/cpu/utilization",
-			"us-east1",
-			"",
+			selector{}.add("resource.labels.zone", "us-east1", "starts_with"),
 			"metric.type=\"compute.googleapis.com/instance/cpu/utilization\" AND resource.labels.zone = starts_with(\"us-east1\")",
 		},
+		{
+			"construct filter with multiple values for the same label",
+			"compute.googleapis.com/instance/cpu/utilization",
+			selector{}.add("resource.labels.zone", "us-east1", "starts_with").add("resource.labels.zone", "us-west1", "starts_with"),
+			"metric.type=\"compute.googleapis.com/instance/cpu/utilization\" AND (resource.labels.zone = starts_with(\"us-east1\") OR resource.labels.zone = starts_with(\"us-west1\"))",
+		},
+		{
+			"construct filter with multiple labels, AND-ed and sorted",
+			"kubernetes.io/container/cpu/core_usage_time",
+			selector{}.add("resource.labels.cluster_name", "prod", "").add("resource.labels.project_id", "my-project", ""),
+			"metric.type=\"kubernetes.io/container/cpu/core_usage_time\" AND resource.labels.cluster_name = \"prod\" AND resource.labels.project_id = \"my-project\"",
+		},
+		{
+			"construct filter with a regex selector",
+			"loadbalancing.googleapis.com/https/backend_latencies",
+			selector{}.add("resource.labels.backend_target_name", "my-backend-.*", "regex"),
+			"metric.type=\"loadbalancing.googleapis.com/https/backend_latencies\" AND resource.labels.backend_target_name = monitoring.regex.full_match(\"my-backend-.*\")",
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.title, func(t *testing.T) {
-			filter := constructFilter(c.m, c.region, c.zone)
+			filter := constructFilter(c.m, c.sel)
 			assert.Equal(t, c.expectedFilter, filter)
 		})
 	}
@@ -52,55 +70,91 @@ func TestGetFilterForMetric(t *testing.T) {
 		title          string
 		m              string
 		r              stackdriverMetricsRequester
+		extra          []labelSelectorConfig
 		expectedFilter string
 	}{
 		{
 			"compute service with zone in config",
 			"compute.googleapis.com/firewall/dropped_bytes_count",
 			stackdriverMetricsRequester{config: config{Zone: "us-central1-a"}},
+			nil,
 			"metric.type=\"compute.googleapis.com/firewall/dropped_bytes_count\" AND resource.labels.zone = \"us-central1-a\"",
 		},
 		{
-			"pubsub service with zone in config",
+			"pubsub service with zone in config is unscoped, pubsub has no zone label",
 			"pubsub.googleapis.com/subscription/ack_message_count",
 			stackdriverMetricsRequester{config: config{Zone: "us-central1-a"}},
+			nil,
 			"metric.type=\"pubsub.googleapis.com/subscription/ack_message_count\"",
 		},
 		{
-			"loadbalancing service with zone in config",
+			"loadbalancing service with zone in config is unscoped, loadbalancing has no zone label",
 			"loadbalancing.googleapis.com/https/backend_latencies",
 			stackdriverMetricsRequester{config: config{Zone: "us-central1-a"}},
+			nil,
 			"metric.type=\"loadbalancing.googleapis.com/https/backend_latencies\"",
 		},
 		{
 			"compute service with region in config",
 			"compute.googleapis.com/firewall/dropped_bytes_count",
 			stackdriverMetricsRequester{config: config{Region: "us-east1"}},
+			nil,
 			"metric.type=\"compute.googleapis.com/firewall/dropped_bytes_count\" AND resource.labels.zone = starts_with(\"us-east1\")",
 		},
 		{
-			"pubsub service with region in config",
+			"pubsub service with region in config is scoped via resource.labels.region",
 			"pubsub.googleapis.com/subscription/ack_message_count",
 			stackdriverMetricsRequester{config: config{Region: "us-east1"}},
-			"metric.type=\"pubsub.googleapis.com/subscription/ack_message_count\"",
+			nil,
+			"metric.type=\"pubsub.googleapis.com/subscription/ack_message_count\" AND resource.labels.region = \"us-east1\"",
 		},
 		{
-			"loadbalancing service with region in config",
+			"loadbalancing service with region in config is scoped via resource.labels.region",
 			"loadbalancing.googleapis.com/https/backend_latencies",
 			stackdriverMetricsRequester{config: config{Region: "us-east1"}},
-			"metric.type=\"loadbalancing.googleapis.com/https/backend_latencies\"",
+			nil,
+			"metric.type=\"loadbalancing.googleapis.com/https/backend_latencies\" AND resource.labels.region = \"us-east1\"",
 		},
 		{
-			"compute service with both region and zone in config",
+			"compute service with both region and zone in config: region wins, zone is dropped",
 			"compute.googleapis.com/firewall/dropped_bytes_count",
 			stackdriverMetricsRequester{config: config{Region: "us-central1", Zone: "us-central1-a"}, logger: logger},
+			nil,
 			"metric.type=\"compute.googleapis.com/firewall/dropped_bytes_count\" AND resource.labels.zone = starts_with(\"us-central1\")",
 		},
+		{
+			"compute service with multiple zones in config",
+			"compute.googleapis.com/firewall/dropped_bytes_count",
+			stackdriverMetricsRequester{config: config{Zones: []string{"us-central1-a", "us-central1-b"}}},
+			nil,
+			"metric.type=\"compute.googleapis.com/firewall/dropped_bytes_count\" AND (resource.labels.zone = \"us-central1-a\" OR resource.labels.zone = \"us-central1-b\")",
+		},
+		{
+			"compute service with multiple regions in config",
+			"compute.googleapis.com/firewall/dropped_bytes_count",
+			stackdriverMetricsRequester{config: config{Regions: []string{"us-east1", "us-west1"}}},
+			nil,
+			"metric.type=\"compute.googleapis.com/firewall/dropped_bytes_count\" AND (resource.labels.zone = starts_with(\"us-east1\") OR resource.labels.zone = starts_with(\"us-west1\"))",
+		},
+		{
+			"GKE container metric scoped by cluster_name",
+			"kubernetes.io/container/cpu/core_usage_time",
+			stackdriverMetricsRequester{config: config{Region: "us-east1"}},
+			[]labelSelectorConfig{{Label: "resource.labels.cluster_name", Values: []string{"prod"}}},
+			"metric.type=\"kubernetes.io/container/cpu/core_usage_time\" AND resource.labels.cluster_name = \"prod\" AND resource.labels.region = \"us-east1\"",
+		},
+		{
+			"L7 backend metric scoped by backend_target_name",
+			"loadbalancing.googleapis.com/https/backend_latencies",
+			stackdriverMetricsRequester{},
+			[]labelSelectorConfig{{Label: "resource.labels.backend_target_name", Values: []string{"my-backend", "my-other-backend"}}},
+			"metric.type=\"loadbalancing.googleapis.com/https/backend_latencies\" AND (resource.labels.backend_target_name = \"my-backend\" OR resource.labels.backend_target_name = \"my-other-backend\")",
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.title, func(t *testing.T) {
-			filter := c.r.getFilterForMetric(c.m)
+			filter := c.r.getFilterForMetric(c.m, c.extra)
 			assert.Equal(t, c.expectedFilter, filter)
 		})
 	}
@@ -164,3 +218,159 @@ func TestGetTimeIntervalAligner(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregationForMetric(t *testing.T) {
+	cache := newMetricDescriptorCache(time.Hour)
+	cache.set("loadbalancing.googleapis.com/https/backend_latencies", defaultIngestDelay, defaultSamplePeriod)
+	cache.set("kubernetes.io/container/cpu/core_usage_time", defaultIngestDelay, defaultSamplePeriod)
+	cache.set("compute.googleapis.com/instance/cpu/utilization", defaultIngestDelay, defaultSamplePeriod)
+
+	r := stackdriverMetricsRequester{
+		config: config{
+			Period: time.Minute,
+			Metrics: []metricAggregationConfig{
+				{
+					MetricType:         "loadbalancing.googleapis.com/https/*",
+					Aligner:            "ALIGN_SUM",
+					CrossSeriesReducer: "REDUCE_PERCENTILE_99",
+					GroupByFields:      []string{"resource.label.backend_target_name"},
+				},
+				{
+					MetricType:         "kubernetes.io/container/*",
+					Aligner:            "ALIGN_SUM",
+					CrossSeriesReducer: "REDUCE_SUM",
+					GroupByFields:      []string{"resource.label.cluster_name"},
+				},
+			},
+		},
+		descriptorCache: cache,
+	}
+
+	cases := []struct {
+		title           string
+		m               string
+		expectedReducer monitoringpb.Aggregation_Reducer
+		expectedGroupBy []string
+	}{
+		{
+			"metric matching a configured glob is aggregated",
+			"loadbalancing.googleapis.com/https/backend_latencies",
+			monitoringpb.Aggregation_REDUCE_PERCENTILE_99,
+			[]string{"resource.label.backend_target_name"},
+		},
+		{
+			"glob's * matches a metric type with more than one remaining path segment",
+			"kubernetes.io/container/cpu/core_usage_time",
+			monitoringpb.Aggregation_REDUCE_SUM,
+			[]string{"resource.label.cluster_name"},
+		},
+		{
+			"metric with no matching entry keeps the default behaviour",
+			"compute.googleapis.com/instance/cpu/utilization",
+			monitoringpb.Aggregation_REDUCE_NONE,
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			agg := r.aggregationForMetric(context.Background(), c.m)
+			assert.Equal(t, c.expectedReducer, agg.CrossSeriesReducer)
+			assert.Equal(t, c.expectedGroupBy, agg.GroupByFields)
+		})
+	}
+}
+
+func TestMatchMetricTypeGlob(t *testing.T) {
+	cases := []struct {
+		title      string
+		pattern    string
+		metricType string
+		expected   bool
+	}{
+		{
+			"exact match with no wildcard",
+			"compute.googleapis.com/instance/cpu/utilization",
+			"compute.googleapis.com/instance/cpu/utilization",
+			true,
+		},
+		{
+			"wildcard spans multiple path segments",
+			"kubernetes.io/container/*",
+			"kubernetes.io/container/cpu/core_usage_time",
+			true,
+		},
+		{
+			"wildcard matches a single remaining segment",
+			"loadbalancing.googleapis.com/https/*",
+			"loadbalancing.googleapis.com/https/backend_latencies",
+			true,
+		},
+		{
+			"non-matching prefix",
+			"compute.googleapis.com/instance/*",
+			"kubernetes.io/container/cpu/core_usage_time",
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			assert.Equal(t, c.expected, matchMetricTypeGlob(c.pattern, c.metricType))
+		})
+	}
+}
+
+func TestEventsFromMQLData(t *testing.T) {
+	descriptor := &monitoringpb.TimeSeriesDescriptor{
+		LabelDescriptors: []*monitoringpb.LabelDescriptor{
+			{Key: "metric.type"},
+			{Key: "resource.label.zone"},
+			{Key: "metric.label.response_code"},
+		},
+		PointDescriptors: []*monitoringpb.TimeSeriesDescriptor_ValueDescriptor{
+			{Key: "value"},
+		},
+	}
+	data := &monitoringpb.TimeSeriesData{
+		LabelValues: []*monitoringpb.LabelValue{
+			{Value: &monitoringpb.LabelValue_StringValue{StringValue: "compute.googleapis.com/instance/cpu/utilization"}},
+			{Value: &monitoringpb.LabelValue_StringValue{StringValue: "us-east1-b"}},
+			{Value: &monitoringpb.LabelValue_StringValue{StringValue: "200"}},
+		},
+		PointData: []*monitoringpb.TimeSeriesData_PointData{
+			{
+				Values: []*monitoringpb.TypedValue{
+					{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: 0.5}},
+				},
+			},
+		},
+	}
+
+	events := eventsFromMQLData(descriptor, data)
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, "compute.googleapis.com/instance/cpu/utilization", events[0]["metric_type"])
+	assert.Equal(t, common.MapStr{"zone": "us-east1-b"}, events[0]["resource"])
+	assert.Equal(t, common.MapStr{"response_code": "200"}, events[0]["labels"])
+	assert.Equal(t, "value", events[0]["value_column"])
+}
+
+func TestMetricDescriptorCache(t *testing.T) {
+	cache := newMetricDescriptorCache(time.Hour)
+
+	_, ok := cache.get("compute.googleapis.com/instance/cpu/utilization")
+	assert.False(t, ok, "a fresh cache should have no entries")
+
+	cache.set("compute.googleapis.com/instance/cpu/utilization", 240*time.Second, 60*time.Second)
+
+	entry, ok := cache.get("compute.googleapis.com/instance/cpu/utilization")
+	assert.True(t, ok)
+	assert.Equal(t, 240*time.Second, entry.ingestDelay)
+	assert.Equal(t, 60*time.Second, entry.samplePeriod)
+
+	expired := newMetricDescriptorCache(-time.Second)
+	expired.set("compute.googleapis.com/instance/cpu/utilization", 240*time.Second, 60*time.Second)
+	_, ok = expired.get("compute.googleapis.com/instance/cpu/utilization")
+	assert.False(t, ok, "an entry past its TTL should be treated as a miss")
+}
@@ -0,0 +1,55 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package stackdriver
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/v7/metricbeat/mb"
+)
+
+func init() {
+	mb.Registry.MustAddMetricSet("googlecloud", "stackdriver", New)
+}
+
+// MetricSet fetches arbitrary Cloud Monitoring (Stackdriver) metrics
+// described by the queries configured by the user.
+type MetricSet struct {
+	mb.BaseMetricSet
+	requester *stackdriverMetricsRequester
+}
+
+// New creates a new stackdriver metricset.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	cfg := defaultConfig()
+	if err := base.Module().UnpackConfig(&cfg); err != nil {
+		return nil, err
+	}
+
+	requester, err := NewStackdriverMetricsRequester(context.Background(), cfg, base.Logger())
+	if err != nil {
+		return nil, errors.Wrap(err, "error initializing stackdriver metrics requester")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		requester:     requester,
+	}, nil
+}
+
+// Fetch runs every configured query and reports the resulting events.
+func (m *MetricSet) Fetch(report mb.ReporterV2) error {
+	events, err := m.requester.Metrics(context.Background(), currentTimeInterval(m.requester.config.Period))
+	if err != nil {
+		return errors.Wrap(err, "error fetching stackdriver metrics")
+	}
+
+	for _, event := range events {
+		report.Event(mb.Event{MetricSetFields: event})
+	}
+	return nil
+}